@@ -0,0 +1,58 @@
+package mailbox
+
+import (
+	"context"
+)
+
+// StoreMode describes how StoreFlags applies a set of flags to a
+// message.
+type StoreMode int
+
+const (
+	// StoreReplace replaces a message's flags entirely.
+	StoreReplace StoreMode = iota
+	// StoreAdd adds flags to a message's existing flags.
+	StoreAdd
+	// StoreRemove removes flags from a message's existing flags.
+	StoreRemove
+)
+
+// Backend abstracts the underlying IMAP client library so that Client
+// can be driven by either the legacy mxk/go-imap implementation or the
+// actively maintained emersion/go-imap one. Implementations are
+// selected via Config.Driver and constructed by Connect.
+type Backend interface {
+	// Search performs a UID SEARCH for criteria and returns the
+	// matching UIDs.
+	Search(criteria SearchCriteria) ([]uint32, error)
+
+	// Fetch retrieves every message identified by uids and streams one
+	// Response per message on the returned channel until all messages
+	// have been delivered. ctx is passed on to MIME parsing; canceling
+	// it does not stop the fetch.
+	Fetch(ctx context.Context, uids []uint32) (chan Response, error)
+
+	// Idle streams mailbox change notifications until ctx is
+	// canceled, falling back to polling if the server does not
+	// support IMAP IDLE; see Client.Idle.
+	Idle(ctx context.Context) (<-chan Event, error)
+
+	// Move relocates the given UIDs into folder.
+	Move(uids []uint32, folder string) error
+
+	// Copy duplicates the given UIDs into folder.
+	Copy(uids []uint32, folder string) error
+
+	// Store applies flags to the given UIDs according to mode.
+	Store(uids []uint32, flags []string, mode StoreMode) error
+
+	// Expunge permanently removes all messages flagged \Deleted from
+	// the selected mailbox.
+	Expunge() error
+
+	// ListFolders lists the folders available on the server.
+	ListFolders() ([]Folder, error)
+
+	// Logout gracefully closes the connection to the server.
+	Logout() error
+}
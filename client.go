@@ -2,81 +2,50 @@ package mailbox
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"time"
-
-	"github.com/mxk/go-imap/imap"
 )
 
 // IMAPDateFormat is the date format used for IMAP SINCE.
 const IMAPDateFormat = "02-Jan-2006"
 
-// Client is a mailbox client.
+// Client is a mailbox client. It delegates all IMAP protocol handling
+// to a Backend chosen by Config.Driver, so callers interact with the
+// same Response/EMail types regardless of the underlying library.
 type Client struct {
-	// IMAP holds the actual IMAP client
-	IMAP *imap.Client
+	backend Backend
+
+	// cfg holds the configuration that was used to establish the
+	// connection. Each backend keeps its own copy, which it uses to
+	// redial after a broken connection.
+	cfg Config
 }
 
-// Connect returns a new IMAP client for the mailbox configured
-// in info.
+// Connect dials, authenticates and selects the mailbox described by
+// info, returning a Client backed by the driver named in info.Driver
+// (DriverEmersion if empty).
 func Connect(info Config) (*Client, error) {
 	var (
-		client *imap.Client
-		err    error
+		backend Backend
+		err     error
 	)
-	if info.TLS {
-		config := new(tls.Config)
-		config.InsecureSkipVerify = info.InsecureSkipVerify
-		client, err = imap.DialTLS(info.Host, config)
-	} else {
-		client, err = imap.Dial(info.Host)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("dialing: %w", err)
-	}
-
-	if info.User != "" {
-		if _, err := client.Login(info.User, info.Password); err != nil {
-			return nil, fmt.Errorf("authenticating: %w", err)
-		}
-	}
-
-	if _, err := imap.Wait(client.Select(info.Folder, info.ReadOnly)); err != nil {
-		return nil, fmt.Errorf("selecting mailbox %q: %w", info.Folder, err)
-	}
-
-	return &Client{
-		IMAP: client,
-	}, nil
-}
-
-// SearchUIDs performs an IMAP UIDSearch on cli and supports searching mails that arrived
-// since a given time. If since is the zero time value it will be ignored.
-func (cli *Client) SearchUIDs(search string, since time.Time) ([]uint32, error) {
-	var specs []imap.Field
-	if len(search) > 0 {
-		specs = append(specs, search)
-	}
 
-	if !since.IsZero() {
-		sinceStr := since.Format(IMAPDateFormat)
-		specs = append(specs, "SINCE", sinceStr)
+	switch info.Driver {
+	case "", DriverEmersion:
+		backend, err = connectEmersion(info)
+	case DriverMXK:
+		backend, err = connectMXK(info)
+	default:
+		return nil, fmt.Errorf("unknown driver %q", info.Driver)
 	}
-
-	cmd, err := imap.Wait(cli.IMAP.UIDSearch(specs...))
 	if err != nil {
 		return nil, err
 	}
 
-	var uids []uint32
-
-	for _, data := range cmd.Data {
-		uids = append(uids, data.SearchResults()...)
-	}
-
-	return uids, nil
+	return &Client{
+		backend: backend,
+		cfg:     info,
+	}, nil
 }
 
 // Response is streamed by FetchUIDs for each mail or error encountered.
@@ -85,48 +54,35 @@ type Response struct {
 	Err    error `json:"error,omitempty"`
 }
 
-// FetchUIDs fetches all mail UIDs specified in the sequence set seq.
-func (cli *Client) FetchUIDs(ctx context.Context, seq *imap.SeqSet) (chan Response, error) {
-	ch := make(chan Response, 100)
-	if seq.Empty() {
-		close(ch)
-		return ch, nil
-	}
-
-	fetchCommand, err := imap.Wait(
-		cli.IMAP.UIDFetch(
-			seq,
-			"INTERNALDATE",
-			"BODY[]",
-			"UID",
-			"RFC822.HEADER",
-		),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("fetching mails: %w", err)
-	}
-
-	go func() {
-		defer close(ch)
-		for _, msgData := range fetchCommand.Data {
-			msgFields := msgData.MessageInfo().Attrs
+// SearchUIDs performs an IMAP UID SEARCH on cli and supports searching
+// mails that arrived since a given time. If since is the zero time
+// value it will be ignored.
+//
+// It is a thin wrapper around Search kept for backwards compatibility;
+// search is passed through as SearchCriteria.Raw, so a caller-supplied
+// raw IMAP search program (e.g. `FROM "x"`) continues to work exactly
+// as before on the mxk driver. New code should build a SearchCriteria
+// directly; on the emersion driver, a non-empty search is rejected
+// with an error instead of being silently reinterpreted as a TEXT
+// search, since go-imap has no equivalent to splice a literal
+// search-key into.
+func (cli *Client) SearchUIDs(search string, since time.Time) ([]uint32, error) {
+	return cli.Search(SearchCriteria{Raw: search, Since: since})
+}
 
-			// make sure is a legit response before we attempt to parse it
-			// deal with unsolicited FETCH responses containing only flags
-			// I'm lookin' at YOU, Gmail!
-			// http://mailman13.u.washington.edu/pipermail/imap-protocol/2014-October/002355.html
-			// http://stackoverflow.com/questions/26262472/gmail-imap-is-sometimes-returning-bad-results-for-fetch
-			if _, ok := msgFields["RFC822.HEADER"]; !ok {
-				continue
-			}
+// Search performs an IMAP UID SEARCH for criteria and returns the
+// matching UIDs.
+func (cli *Client) Search(criteria SearchCriteria) ([]uint32, error) {
+	return cli.backend.Search(criteria)
+}
 
-			mail, err := MailFromFields(ctx, msgFields)
-			ch <- Response{
-				EMail: mail,
-				Err:   err,
-			}
-		}
-	}()
+// FetchUIDs fetches all mails identified by uids, streaming one
+// Response per message or error encountered on the returned channel.
+func (cli *Client) FetchUIDs(ctx context.Context, uids []uint32) (chan Response, error) {
+	return cli.backend.Fetch(ctx, uids)
+}
 
-	return ch, nil
+// Logout gracefully closes the connection to the mailbox server.
+func (cli *Client) Logout() error {
+	return cli.backend.Logout()
 }
@@ -0,0 +1,45 @@
+package mailbox
+
+// Driver selects which underlying IMAP client library a Client is
+// backed by.
+type Driver string
+
+const (
+	// DriverEmersion backs the Client with github.com/emersion/go-imap,
+	// the actively maintained client with IMAP4rev2/UTF-8/SASL support.
+	// This is the default driver.
+	DriverEmersion Driver = "emersion"
+	// DriverMXK backs the Client with the legacy github.com/mxk/go-imap
+	// client, kept for compatibility with existing deployments.
+	DriverMXK Driver = "mxk"
+)
+
+// Config configures how Connect dials, authenticates against, and
+// selects a mailbox.
+type Config struct {
+	// Driver selects the IMAP backend implementation. Defaults to
+	// DriverEmersion if empty.
+	Driver Driver
+	// Host is the "host:port" address of the IMAP server.
+	Host string
+	// TLS enables an implicit TLS connection.
+	TLS bool
+	// InsecureSkipVerify disables TLS certificate verification. Only
+	// has an effect if TLS is set.
+	InsecureSkipVerify bool
+	// User is the login user name. If empty, Connect skips
+	// authentication and the connection is used anonymously.
+	User string
+	// Password is the login password for User.
+	Password string
+	// Folder is the mailbox folder to select after connecting.
+	Folder string
+	// ReadOnly selects Folder in read-only mode. Client methods that
+	// mutate mailbox state return an error if ReadOnly is set.
+	ReadOnly bool
+	// MaxInMemory bounds how large a decoded attachment body may grow
+	// before Fetch spills it to a temp file instead of holding it in
+	// memory; see ParseOptions.MaxInMemory. Zero selects ParseOptions'
+	// default of 1 MiB.
+	MaxInMemory int64
+}
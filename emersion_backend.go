@@ -0,0 +1,488 @@
+package mailbox
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"time"
+
+	imapv2 "github.com/emersion/go-imap"
+	idle "github.com/emersion/go-imap-idle"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/client"
+)
+
+// emersionBackend implements Backend on top of the actively maintained
+// github.com/emersion/go-imap client. It is the default driver and,
+// unlike mxkBackend, supports IMAP4rev2, UTF-8 literals and SASL
+// authentication mechanisms.
+type emersionBackend struct {
+	client *client.Client
+	cfg    Config
+}
+
+func connectEmersion(info Config) (Backend, error) {
+	var (
+		c   *client.Client
+		err error
+	)
+	if info.TLS {
+		c, err = client.DialTLS(info.Host, &tls.Config{InsecureSkipVerify: info.InsecureSkipVerify})
+	} else {
+		c, err = client.Dial(info.Host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	if info.User != "" {
+		if err := c.Login(info.User, info.Password); err != nil {
+			return nil, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if _, err := c.Select(info.Folder, info.ReadOnly); err != nil {
+		return nil, fmt.Errorf("selecting mailbox %q: %w", info.Folder, err)
+	}
+
+	return &emersionBackend{client: c, cfg: info}, nil
+}
+
+func (b *emersionBackend) Search(criteria SearchCriteria) ([]uint32, error) {
+	native, err := toEmersionCriteria(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("rendering search criteria: %w", err)
+	}
+
+	return b.client.UidSearch(native)
+}
+
+// toEmersionCriteria maps our backend-agnostic SearchCriteria onto the
+// go-imap client library's native, structured SearchCriteria.
+func toEmersionCriteria(c SearchCriteria) (*imapv2.SearchCriteria, error) {
+	out := imapv2.NewSearchCriteria()
+	out.Header = make(textproto.MIMEHeader)
+
+	if c.From != "" {
+		out.Header.Add("From", c.From)
+	}
+	if c.To != "" {
+		out.Header.Add("To", c.To)
+	}
+	if c.Subject != "" {
+		out.Header.Add("Subject", c.Subject)
+	}
+	if c.Body != "" {
+		out.Body = append(out.Body, c.Body)
+	}
+	for name, value := range c.Header {
+		out.Header.Add(name, value)
+	}
+
+	out.Since = c.Since
+	out.Before = c.Before
+	out.Larger = c.Larger
+	out.Smaller = c.Smaller
+
+	if c.Seen != nil {
+		addFlagCriterion(out, imapv2.SeenFlag, *c.Seen)
+	}
+	if c.Answered != nil {
+		addFlagCriterion(out, imapv2.AnsweredFlag, *c.Answered)
+	}
+	if c.Flagged != nil {
+		addFlagCriterion(out, imapv2.FlaggedFlag, *c.Flagged)
+	}
+
+	if c.Not != nil {
+		notCriteria, err := toEmersionCriteria(*c.Not)
+		if err != nil {
+			return nil, fmt.Errorf("rendering Not: %w", err)
+		}
+		out.Not = append(out.Not, notCriteria)
+	}
+
+	if len(c.Or) == 1 {
+		// search.go's renderOr applies a single Or element directly,
+		// ANDing it into the rest of the criteria rather than OR-ing it
+		// against nothing; match that here instead of silently dropping
+		// it, which is what appending to out.Or (a list of OR pairs)
+		// would otherwise do for a single element.
+		single, err := toEmersionCriteria(c.Or[0])
+		if err != nil {
+			return nil, fmt.Errorf("rendering Or: %w", err)
+		}
+		mergeEmersionCriteria(out, single)
+	} else if len(c.Or) >= 2 {
+		orCrit, err := orCriteria(c.Or)
+		if err != nil {
+			return nil, err
+		}
+		out.Or = append(out.Or, orCrit.Or...)
+	}
+
+	if c.Raw != "" {
+		// The go-imap client only exposes a structured SearchCriteria,
+		// not a way to splice in a literal search-key, so there is no
+		// faithful translation of an arbitrary raw IMAP search program.
+		// Mapping it onto Text (substring search over the whole
+		// message) would silently change what SearchUIDs callers match
+		// against, so refuse instead: callers relying on Raw must use
+		// DriverMXK.
+		return nil, fmt.Errorf("SearchCriteria.Raw is not supported by the emersion driver; use DriverMXK or build the query from the other SearchCriteria fields")
+	}
+
+	return out, nil
+}
+
+// mergeEmersionCriteria ANDs src's criteria into dst in place, used to
+// fold a single-element Or into its surrounding criteria. Since and
+// Before and Larger and Smaller are kept from dst if already set,
+// mirroring the "first write wins" wholesale-replacement the rest of
+// toEmersionCriteria uses for those fields.
+func mergeEmersionCriteria(dst, src *imapv2.SearchCriteria) {
+	for name, values := range src.Header {
+		for _, value := range values {
+			dst.Header.Add(name, value)
+		}
+	}
+	dst.Body = append(dst.Body, src.Body...)
+	dst.Text = append(dst.Text, src.Text...)
+	dst.WithFlags = append(dst.WithFlags, src.WithFlags...)
+	dst.WithoutFlags = append(dst.WithoutFlags, src.WithoutFlags...)
+	dst.Not = append(dst.Not, src.Not...)
+	dst.Or = append(dst.Or, src.Or...)
+
+	if dst.Since.IsZero() {
+		dst.Since = src.Since
+	}
+	if dst.Before.IsZero() {
+		dst.Before = src.Before
+	}
+	if dst.Larger == 0 {
+		dst.Larger = src.Larger
+	}
+	if dst.Smaller == 0 {
+		dst.Smaller = src.Smaller
+	}
+}
+
+func addFlagCriterion(out *imapv2.SearchCriteria, flag string, want bool) {
+	if want {
+		out.WithFlags = append(out.WithFlags, flag)
+	} else {
+		out.WithoutFlags = append(out.WithoutFlags, flag)
+	}
+}
+
+// orCriteria folds two or more criteria into a single *SearchCriteria
+// whose Or field holds one nested two-armed pair, mirroring the
+// pairwise folding SearchCriteria.Render uses for the mxk backend. It
+// must be called with at least two elements.
+func orCriteria(criteria []SearchCriteria) (*imapv2.SearchCriteria, error) {
+	if len(criteria) == 2 {
+		a, err := toEmersionCriteria(criteria[0])
+		if err != nil {
+			return nil, fmt.Errorf("rendering Or: %w", err)
+		}
+		b, err := toEmersionCriteria(criteria[1])
+		if err != nil {
+			return nil, fmt.Errorf("rendering Or: %w", err)
+		}
+
+		combined := imapv2.NewSearchCriteria()
+		combined.Or = append(combined.Or, [2]*imapv2.SearchCriteria{a, b})
+		return combined, nil
+	}
+
+	first, err := toEmersionCriteria(criteria[0])
+	if err != nil {
+		return nil, fmt.Errorf("rendering Or: %w", err)
+	}
+
+	rest, err := orCriteria(criteria[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	combined := imapv2.NewSearchCriteria()
+	combined.Or = append(combined.Or, [2]*imapv2.SearchCriteria{first, rest})
+	return combined, nil
+}
+
+func (b *emersionBackend) Fetch(ctx context.Context, uids []uint32) (chan Response, error) {
+	ch := make(chan Response, 100)
+	if len(uids) == 0 {
+		close(ch)
+		return ch, nil
+	}
+
+	seqset := new(imapv2.SeqSet)
+	seqset.AddNum(uids...)
+
+	headerSection := &imapv2.BodySectionName{BodyPartName: imapv2.BodyPartName{Specifier: imapv2.HeaderSpecifier}}
+	bodySection := &imapv2.BodySectionName{BodyPartName: imapv2.BodyPartName{Specifier: imapv2.TextSpecifier}}
+	items := []imapv2.FetchItem{
+		imapv2.FetchInternalDate,
+		imapv2.FetchUid,
+		imapv2.FetchEnvelope,
+		imapv2.FetchFlags,
+		headerSection.FetchItem(),
+		bodySection.FetchItem(),
+	}
+
+	messages := make(chan *imapv2.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.client.UidFetch(seqset, items, messages)
+	}()
+
+	opts := ParseOptions{MaxInMemory: b.cfg.MaxInMemory}
+
+	go func() {
+		defer close(ch)
+
+		for msg := range messages {
+			headerLiteral := msg.GetBody(headerSection)
+			bodyLiteral := msg.GetBody(bodySection)
+			if headerLiteral == nil || bodyLiteral == nil {
+				continue
+			}
+
+			// Headers are small, so read them eagerly. The body literal
+			// is handed to mailFromRaw as-is: it streams straight off
+			// the IMAP connection into MIME parsing, so a large
+			// attachment is spilled to disk under opts instead of
+			// passing through a full in-memory copy here.
+			header, err := io.ReadAll(headerLiteral)
+			if err != nil {
+				ch <- Response{Err: fmt.Errorf("reading header: %w", err)}
+				continue
+			}
+
+			result, err := mailFromRaw(ctx, header, bodyLiteral, msg.Uid, msg.InternalDate, msg.Flags, envelopeFromMessage(msg), opts)
+			ch <- Response{EMail: result, Err: err}
+		}
+
+		if err := <-done; err != nil {
+			ch <- Response{Err: fmt.Errorf("fetching mails: %w", err)}
+		}
+	}()
+
+	return ch, nil
+}
+
+// envelopeFromMessage converts the native ENVELOPE data on msg into an
+// Envelope, for use as mailFromRaw's header-parsing fallback. Returns
+// nil if msg carries no envelope.
+func envelopeFromMessage(msg *imapv2.Message) *Envelope {
+	env := msg.Envelope
+	if env == nil {
+		return nil
+	}
+
+	return &Envelope{
+		From:      firstAddress(env.From),
+		To:        convertAddresses(env.To),
+		Cc:        convertAddresses(env.Cc),
+		Bcc:       convertAddresses(env.Bcc),
+		ReplyTo:   firstAddress(env.ReplyTo),
+		Sender:    firstAddress(env.Sender),
+		Subject:   env.Subject,
+		MessageID: trimMessageID(env.MessageId),
+		InReplyTo: trimMessageID(env.InReplyTo),
+		Date:      env.Date,
+	}
+}
+
+func convertAddresses(addrs []*imapv2.Address) []*mail.Address {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	result := make([]*mail.Address, len(addrs))
+	for i, a := range addrs {
+		result[i] = &mail.Address{Name: a.PersonalName, Address: a.Address()}
+	}
+	return result
+}
+
+func firstAddress(addrs []*imapv2.Address) *mail.Address {
+	converted := convertAddresses(addrs)
+	if len(converted) == 0 {
+		return nil
+	}
+	return converted[0]
+}
+
+func (b *emersionBackend) Idle(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, 16)
+
+	go b.idleLoop(ctx, ch)
+
+	return ch, nil
+}
+
+func (b *emersionBackend) idleLoop(ctx context.Context, ch chan<- Event) {
+	defer close(ch)
+
+	for ctx.Err() == nil {
+		if err := b.idleOnce(ctx, ch); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := b.reconnect(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// idleOnce issues a single IDLE command, translating mailbox updates
+// into events until ctx is canceled or the connection breaks. The
+// idle package itself re-issues IDLE every idleRestartInterval per
+// RFC 2177 and falls back to NOOP polling on the same cadence if the
+// server doesn't advertise IDLE.
+func (b *emersionBackend) idleOnce(ctx context.Context, ch chan<- Event) error {
+	updates := make(chan client.Update, 16)
+	b.client.Updates = updates
+	defer func() { b.client.Updates = nil }()
+
+	idleClient := idle.NewClient(b.client)
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- idleClient.IdleWithFallback(stop, idleRestartInterval)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return <-done
+			}
+			translateUpdate(update, ch)
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// translateUpdate converts an unsolicited client.Update observed
+// while idling into an Event, if it is one we care about. EventNewMail
+// only carries Seq (the mailbox's new message count): resolving the
+// UID would require issuing a command while IDLE is in progress, so
+// callers must follow up with Search/SearchUIDs if they need it; see
+// Event.UID.
+func translateUpdate(update client.Update, ch chan<- Event) {
+	switch u := update.(type) {
+	case *client.MailboxUpdate:
+		ch <- Event{Type: EventNewMail, Seq: u.Mailbox.Messages}
+	case *client.ExpungeUpdate:
+		ch <- Event{Type: EventExpunge, Seq: u.SeqNum}
+	case *client.MessageUpdate:
+		ch <- Event{Type: EventFlagChange, Seq: u.Message.SeqNum, Flags: u.Message.Flags}
+	}
+}
+
+// reconnect redials the server using the configuration that was
+// passed to Connect and swaps in the freshly established session.
+func (b *emersionBackend) reconnect() error {
+	fresh, err := connectEmersion(b.cfg)
+	if err != nil {
+		return err
+	}
+	b.client = fresh.(*emersionBackend).client
+	return nil
+}
+
+func (b *emersionBackend) Move(uids []uint32, folder string) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seqset := new(imapv2.SeqSet)
+	seqset.AddNum(uids...)
+
+	mv := move.NewClient(b.client)
+	return mv.UidMoveWithFallback(seqset, folder)
+}
+
+func (b *emersionBackend) Copy(uids []uint32, folder string) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seqset := new(imapv2.SeqSet)
+	seqset.AddNum(uids...)
+
+	return b.client.UidCopy(seqset, folder)
+}
+
+func (b *emersionBackend) Store(uids []uint32, flags []string, mode StoreMode) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seqset := new(imapv2.SeqSet)
+	seqset.AddNum(uids...)
+
+	op := imapv2.SetFlags
+	switch mode {
+	case StoreAdd:
+		op = imapv2.AddFlags
+	case StoreRemove:
+		op = imapv2.RemoveFlags
+	}
+
+	ifaceFlags := make([]interface{}, len(flags))
+	for i, f := range flags {
+		ifaceFlags[i] = f
+	}
+
+	return b.client.UidStore(seqset, imapv2.FormatFlagsOp(op, true), ifaceFlags, nil)
+}
+
+func (b *emersionBackend) Expunge() error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	return b.client.Expunge(nil)
+}
+
+func (b *emersionBackend) ListFolders() ([]Folder, error) {
+	mailboxes := make(chan *imapv2.MailboxInfo, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.client.List("", "*", mailboxes)
+	}()
+
+	var folders []Folder
+	for m := range mailboxes {
+		folders = append(folders, Folder{
+			Name:       m.Name,
+			Delimiter:  m.Delimiter,
+			Attributes: m.Attributes,
+		})
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("listing folders: %w", err)
+	}
+
+	return folders, nil
+}
+
+func (b *emersionBackend) Logout() error {
+	return b.client.Logout()
+}
@@ -0,0 +1,12 @@
+package mailbox
+
+// Folder describes a mailbox folder as reported by Client.ListFolders.
+type Folder struct {
+	// Name is the folder's full, hierarchical name.
+	Name string `json:"name"`
+	// Delimiter separates hierarchy levels in Name.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Attributes holds the folder's IMAP attributes, e.g. \Noselect or
+	// \HasChildren.
+	Attributes []string `json:"attributes,omitempty"`
+}
@@ -0,0 +1,65 @@
+package mailbox
+
+import (
+	"context"
+	"time"
+)
+
+// EventType identifies the kind of mailbox change reported by the
+// event stream returned from Client.Idle.
+type EventType int
+
+const (
+	// EventNewMail is emitted when the server reports a new message,
+	// i.e. an untagged EXISTS response.
+	EventNewMail EventType = iota
+	// EventExpunge is emitted when a message has been permanently
+	// removed from the mailbox, i.e. an untagged EXPUNGE response.
+	EventExpunge
+	// EventFlagChange is emitted when the flags of a message changed,
+	// i.e. an untagged FETCH response carrying a FLAGS attribute.
+	EventFlagChange
+)
+
+// Event is sent on the channel returned by Client.Idle whenever the
+// server reports a mailbox change while idling or, in the mxk driver's
+// polling fallback mode, whenever a new UID is discovered.
+type Event struct {
+	// Type is the kind of change that occurred.
+	Type EventType
+	// Seq is the message sequence number as reported by the server.
+	// For EventNewMail observed over IDLE it is the mailbox's message
+	// count from the EXISTS response; it is zero for EventNewMail
+	// events raised by the mxk driver's polling fallback.
+	Seq uint32
+	// UID is the UID of the affected message. Only populated for
+	// EventNewMail events raised by the mxk driver's polling fallback,
+	// which resolves it via Search; the live IDLE path only ever learns of
+	// new mail through an untagged EXISTS response, which carries a
+	// message count, not a UID, and resolving one would require
+	// issuing a command while IDLE is still in progress. Callers that
+	// need the UID of new mail observed over a live IDLE connection
+	// must follow up with Search/SearchUIDs themselves.
+	UID uint32
+	// Flags holds the new flag set for EventFlagChange events.
+	Flags []string
+}
+
+// idleRestartInterval is how often IDLE is re-issued, comfortably
+// below the 30 minute server timeout mandated by RFC 2177.
+const idleRestartInterval = 29 * time.Minute
+
+// Idle starts an IMAP IDLE loop and streams mailbox change
+// notifications on the returned channel until ctx is canceled. IDLE is
+// automatically re-issued every 29 minutes per RFC 2177 and the
+// connection is transparently redialed if it breaks. If the server
+// does not advertise the IDLE capability, Idle falls back to polling on
+// the same cadence: the mxk driver polls Search and reports the new
+// UIDs it finds, the emersion driver polls with NOOP and reports the
+// same Seq-only events as over IDLE.
+//
+// The returned channel is closed once ctx is done, so callers should
+// range over it until it closes rather than watching ctx directly.
+func (cli *Client) Idle(ctx context.Context) (<-chan Event, error) {
+	return cli.backend.Idle(ctx)
+}
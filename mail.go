@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"mime"
 	"net/mail"
 	"net/textproto"
@@ -11,8 +13,6 @@ import (
 	"time"
 
 	"github.com/mxk/go-imap/imap"
-	// Make sure we have the charset data available
-	_ "github.com/paulrosania/go-charset/data"
 )
 
 // EMail represents a parsed E-Mail fetched from a mailbox.
@@ -25,6 +25,29 @@ type EMail struct {
 	From *mail.Address `json:"from"`
 	// To holds a parsed address list of the receipients.
 	To []*mail.Address `json:"to"`
+	// Cc holds a parsed address list of the carbon-copy receipients.
+	Cc []*mail.Address `json:"cc,omitempty"`
+	// Bcc holds a parsed address list of the blind-carbon-copy
+	// receipients.
+	Bcc []*mail.Address `json:"bcc,omitempty"`
+	// ReplyTo holds the parsed Reply-To address, if set.
+	ReplyTo *mail.Address `json:"replyTo,omitempty"`
+	// Sender holds the parsed Sender address, if set.
+	Sender *mail.Address `json:"sender,omitempty"`
+	// MessageID is the Message-Id header with surrounding angle
+	// brackets trimmed. It identifies this mail across mailboxes and
+	// can be used for deduplication.
+	MessageID string `json:"messageId,omitempty"`
+	// InReplyTo is the Message-Id, with brackets trimmed, of the mail
+	// this one is a reply to.
+	InReplyTo string `json:"inReplyTo,omitempty"`
+	// References holds the Message-Ids, with brackets trimmed, of the
+	// thread this mail belongs to.
+	References []string `json:"references,omitempty"`
+	// Date is the parsed RFC 5322 Date header, i.e. when the mail
+	// claims to have been sent. Unlike InternalDate this value is
+	// client-supplied and must not be trusted for ordering.
+	Date time.Time `json:"date"`
 	// InternalDate is the date at which the email was received
 	// by the mailbox.
 	InternalDate time.Time `json:"internalDate"`
@@ -36,47 +59,173 @@ type EMail struct {
 	// this mail. Note that UID is only valid as long as the
 	// mailbox UIDVALIDITY has changed.
 	UID uint32 `json:"uid"`
+	// Flags holds the IMAP flags set on the message, e.g. \Seen or
+	// \Answered.
+	Flags []string `json:"flags,omitempty"`
 }
 
-// MailFromFields creates a EMail from a set of IMAP fields. It expects
-// RFC822.HEADER, BODY[], INTERNALDATE and UID fields to be set.
+// Envelope holds the address, subject and threading fields reported by
+// the IMAP ENVELOPE fetch item. Backends populate it from their client
+// library's native envelope type, and mailFromRaw falls back to it
+// whenever the equivalent RFC822 header is missing or fails to parse.
+type Envelope struct {
+	From      *mail.Address
+	To        []*mail.Address
+	Cc        []*mail.Address
+	Bcc       []*mail.Address
+	ReplyTo   *mail.Address
+	Sender    *mail.Address
+	Subject   string
+	MessageID string
+	InReplyTo string
+	Date      time.Time
+}
+
+// MailFromFields creates a EMail from a set of IMAP fields using the
+// default ParseOptions. See MailFromFieldsWithOptions to control the
+// in-memory spill threshold for large attachment bodies.
 func MailFromFields(ctx context.Context, fields imap.FieldMap) (*EMail, error) {
-	// copy the email in it's raw form to a buffer
-	rawMail := new(bytes.Buffer)
-	rawMail.Write(imap.AsBytes(fields["RFC822.HEADER"]))
-	rawMail.Write([]byte("\n\n"))
-	rawBody := imap.AsBytes(fields["BODY[]"])
-	rawMail.Write(rawBody)
+	return MailFromFieldsWithOptions(ctx, fields, ParseOptions{})
+}
+
+// MailFromFieldsWithOptions is like MailFromFields but passes opts
+// through to the underlying MIME parsing, so large attachment bodies
+// can be spilled to disk instead of held in memory. It expects
+// RFC822.HEADER, BODY[], INTERNALDATE and UID fields to be set. FLAGS,
+// if present, populates EMail.Flags.
+func MailFromFieldsWithOptions(ctx context.Context, fields imap.FieldMap, opts ParseOptions) (*EMail, error) {
+	var flags []string
+	if raw, ok := fields["FLAGS"]; ok {
+		for _, f := range imap.AsList(raw) {
+			flags = append(flags, imap.AsAtom(f))
+		}
+	}
+
+	return mailFromRaw(
+		ctx,
+		imap.AsBytes(fields["RFC822.HEADER"]),
+		bytes.NewReader(imap.AsBytes(fields["BODY[]"])),
+		imap.AsNumber(fields["UID"]),
+		imap.AsDateTime(fields["INTERNALDATE"]),
+		flags,
+		nil,
+		opts,
+	)
+}
+
+// mailFromRaw assembles an EMail from a separately fetched message
+// header and body, as returned by either the mxk or the emersion
+// backend. body is streamed rather than buffered up front, so a large
+// attachment only ends up in memory if opts allows it. It is the
+// shared core of MailFromFields.
+func mailFromRaw(ctx context.Context, header []byte, body io.Reader, uid uint32, internalDate time.Time, flags []string, envelope *Envelope, opts ParseOptions) (*EMail, error) {
+	rawMail := io.MultiReader(bytes.NewReader(header), strings.NewReader("\n\n"), body)
 
 	m, err := mail.ReadMessage(rawMail)
 	if err != nil {
 		return nil, fmt.Errorf("parsing mail: %w", err)
 	}
 
+	result, err := emailFromMessage(ctx, m, uid, internalDate, flags, envelope, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// emailFromMessage builds an EMail from an already-parsed RFC 5322
+// message, falling back to envelope for any address, subject or
+// threading field that the header doesn't carry or that fails to
+// parse. envelope may be nil, in which case no fallback is attempted.
+// m.Body is parsed under opts, so a large attachment body is spilled
+// to disk rather than held in memory; see ParseOptions.
+func emailFromMessage(ctx context.Context, m *mail.Message, uid uint32, internalDate time.Time, flags []string, envelope *Envelope, opts ParseOptions) (*EMail, error) {
 	from, err := mail.ParseAddress(m.Header.Get("From"))
 	if err != nil {
-		return nil, fmt.Errorf("parsing From: %w", err)
+		if envelope == nil || envelope.From == nil {
+			return nil, fmt.Errorf("parsing From: %w", err)
+		}
+		from = envelope.From
 	}
 
 	to, err := m.Header.AddressList("To")
 	if err != nil {
-		return nil, fmt.Errorf("parsing To: %w", err)
+		if envelope == nil || len(envelope.To) == 0 {
+			return nil, fmt.Errorf("parsing To: %w", err)
+		}
+		to = envelope.To
+	}
+
+	cc, _ := m.Header.AddressList("Cc")
+	if len(cc) == 0 && envelope != nil {
+		cc = envelope.Cc
+	}
+
+	bcc, _ := m.Header.AddressList("Bcc")
+	if len(bcc) == 0 && envelope != nil {
+		bcc = envelope.Bcc
+	}
+
+	replyTo, _ := mail.ParseAddress(m.Header.Get("Reply-To"))
+	if replyTo == nil && envelope != nil {
+		replyTo = envelope.ReplyTo
+	}
+
+	sender, _ := mail.ParseAddress(m.Header.Get("Sender"))
+	if sender == nil && envelope != nil {
+		sender = envelope.Sender
+	}
+
+	messageID := trimMessageID(m.Header.Get("Message-Id"))
+	if messageID == "" && envelope != nil {
+		messageID = envelope.MessageID
+	}
+
+	inReplyTo := trimMessageID(m.Header.Get("In-Reply-To"))
+	if inReplyTo == "" && envelope != nil {
+		inReplyTo = envelope.InReplyTo
+	}
+
+	var references []string
+	for _, ref := range strings.Fields(m.Header.Get("References")) {
+		references = append(references, trimMessageID(ref))
+	}
+
+	subject := decodeString(m.Header.Get("Subject"))
+	if subject == "" && envelope != nil {
+		subject = envelope.Subject
+	}
+
+	date, dateErr := m.Header.Date()
+	if dateErr != nil && envelope != nil {
+		date = envelope.Date
 	}
 
 	result := &EMail{
 		Raw:          m,
-		InternalDate: imap.AsDateTime(fields["INTERNALDATE"]),
+		InternalDate: internalDate,
+		Date:         date,
 		Precedence:   m.Header.Get("Precedence"),
 		From:         from,
 		To:           to,
-		Subject:      decodeString(m.Header.Get("Subject")),
-		UID:          imap.AsNumber(fields["UID"]),
+		Cc:           cc,
+		Bcc:          bcc,
+		ReplyTo:      replyTo,
+		Sender:       sender,
+		MessageID:    messageID,
+		InReplyTo:    inReplyTo,
+		References:   references,
+		Subject:      subject,
+		UID:          uid,
+		Flags:        flags,
 	}
 
-	parsed, err := ParseMIMEBody(
+	parsed, err := ParseMIMEBodyWithOptions(
 		ctx,
 		textproto.MIMEHeader(m.Header),
-		bytes.NewReader(rawBody),
+		m.Body,
+		opts,
 	)
 	if err != nil {
 		return result, fmt.Errorf("parsing body: %w", err)
@@ -86,6 +235,75 @@ func MailFromFields(ctx context.Context, fields imap.FieldMap) (*EMail, error) {
 	return result, nil
 }
 
+// trimMessageID strips the surrounding angle brackets from a
+// Message-Id, In-Reply-To or References token.
+func trimMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// EMLToEMail parses a standalone RFC 5322 message, such as one read
+// from a .eml file on disk, into an EMail. It works identically to
+// MailFromFields but without requiring a live IMAP fetch, so InternalDate
+// and UID are left at their zero values.
+func EMLToEMail(r io.Reader) (*EMail, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading eml: %w", err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing mail: %w", err)
+	}
+
+	return emailFromMessage(context.Background(), m, 0, time.Time{}, nil, nil, ParseOptions{})
+}
+
+// WriteEML serializes e as a standalone RFC 5322 message onto w,
+// re-encoding its MultiPart tree with valid MIME boundaries and
+// Content-Transfer-Encodings. The envelope headers (From, To, Subject,
+// Date, Precedence) are re-derived from the parsed fields rather than
+// copied from Raw, so WriteEML also works for EMails assembled without
+// one. Cc, Bcc, ReplyTo, Sender, MessageID, InReplyTo, References and
+// Flags are not re-serialized, so round-tripping an EMail through
+// WriteEML followed by EMLToEMail loses those fields.
+func (e *EMail) WriteEML(w io.Writer) error {
+	header := make(textproto.MIMEHeader)
+	if e.From != nil {
+		header.Set("From", e.From.String())
+	}
+	if len(e.To) > 0 {
+		addrs := make([]string, len(e.To))
+		for i, a := range e.To {
+			addrs[i] = a.String()
+		}
+		header.Set("To", strings.Join(addrs, ", "))
+	}
+	if e.Subject != "" {
+		header.Set("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	}
+	if !e.Date.IsZero() {
+		header.Set("Date", e.Date.Format(time.RFC1123Z))
+	}
+	if e.Precedence != "" {
+		header.Set("Precedence", e.Precedence)
+	}
+
+	contentHeader, boundary := mimeHeader(&e.MultiPart)
+	for key, values := range contentHeader {
+		header[key] = values
+	}
+
+	if err := writeMIMEHeader(w, header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	return writeMIMEBody(w, &e.MultiPart, boundary)
+}
+
 func hasEncoding(word string) bool {
 	return strings.Contains(word, "=?") && strings.Contains(word, "?=")
 }
@@ -0,0 +1,66 @@
+package mailbox
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestEMLRoundTrip covers WriteEML -> EMLToEMail for a plain-text
+// message, the minimal case the pair is meant to support.
+func TestEMLRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	const raw = "From: Alice <alice@example.com>\r\n" +
+		"To: Bob <bob@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"\r\n" +
+		"hi there\r\n"
+
+	original, err := EMLToEMail(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("EMLToEMail: %s", err)
+	}
+
+	if !original.InternalDate.IsZero() {
+		t.Errorf("InternalDate = %v, want zero value for a standalone .eml import", original.InternalDate)
+	}
+
+	var buf bytes.Buffer
+	if err := original.WriteEML(&buf); err != nil {
+		t.Fatalf("WriteEML: %s", err)
+	}
+
+	roundTripped, err := EMLToEMail(&buf)
+	if err != nil {
+		t.Fatalf("EMLToEMail on the re-serialized message: %s", err)
+	}
+
+	if roundTripped.From.String() != original.From.String() {
+		t.Errorf("From = %q, want %q", roundTripped.From, original.From)
+	}
+	if len(roundTripped.To) != 1 || roundTripped.To[0].String() != original.To[0].String() {
+		t.Errorf("To = %v, want %v", roundTripped.To, original.To)
+	}
+	if roundTripped.Subject != original.Subject {
+		t.Errorf("Subject = %q, want %q", roundTripped.Subject, original.Subject)
+	}
+	if !roundTripped.Date.Equal(original.Date) {
+		t.Errorf("Date = %v, want %v", roundTripped.Date, original.Date)
+	}
+
+	body, err := roundTripped.Open()
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer body.Close()
+
+	got := make([]byte, 64)
+	n, _ := body.Read(got)
+	if strings.TrimSpace(string(got[:n])) != "hi there" {
+		t.Errorf("body = %q, want %q", got[:n], "hi there")
+	}
+}
@@ -1,22 +1,44 @@
 package mailbox
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
 	"net/textproto"
+	"os"
 	"regexp"
 	"strings"
 
-	"github.com/paulrosania/go-charset/charset"
-	"github.com/sloonz/go-qprintable"
 	"github.com/tierklinik-dobersberg/logger"
+	"golang.org/x/text/encoding/ianaindex"
 )
 
+// defaultMaxInMemory is the ParseOptions.MaxInMemory threshold used
+// when a caller doesn't specify one.
+const defaultMaxInMemory = 1 << 20 // 1 MiB
+
+// ParseOptions configures ParseMIMEBodyWithOptions.
+type ParseOptions struct {
+	// MaxInMemory is the largest a decoded part body may grow before it
+	// is spilled to a temp file instead of being held in MultiPart.Body.
+	// Zero selects a default of 1 MiB.
+	MaxInMemory int64
+}
+
+func (o ParseOptions) maxInMemory() int64 {
+	if o.MaxInMemory <= 0 {
+		return defaultMaxInMemory
+	}
+	return o.MaxInMemory
+}
+
 // MultiPart is a multi-part email.
 type MultiPart struct {
 	// MimeType is the parsed mime-type of this message part.
@@ -33,8 +55,58 @@ type MultiPart struct {
 	// Mutally exclusive with Body.
 	Children []MultiPart `json:"children,omitempty"`
 	// Body is the actual body of the multipart message. Only set
-	// if this part is not a multipart message by itself.
+	// if this part is not a multipart message by itself and the body
+	// was small enough to stay under ParseOptions.MaxInMemory; larger
+	// bodies are spilled to disk and only reachable through Open.
 	Body []byte `json:"body,omitempty"`
+	// source, if set, reopens a body that was spilled to disk during
+	// parsing because it exceeded ParseOptions.MaxInMemory.
+	source bodySource
+}
+
+// bodySource supplies repeatable read access to a part's body once it
+// no longer lives in Body.
+type bodySource interface {
+	Open() (io.ReadCloser, error)
+}
+
+// fileSource reopens a decoded body that ParseMIMEBodyWithOptions
+// spilled to a temp file because it exceeded MaxInMemory.
+type fileSource string
+
+func (f fileSource) Open() (io.ReadCloser, error) {
+	return os.Open(string(f))
+}
+
+// Open returns a reader over mp's decoded body. Bodies small enough to
+// have been held in memory are served from Body; bodies that were
+// spilled to disk during parsing are reopened from their temp file.
+// The caller must close the returned reader.
+func (mp *MultiPart) Open() (io.ReadCloser, error) {
+	if mp.source != nil {
+		return mp.source.Open()
+	}
+	return io.NopCloser(bytes.NewReader(mp.Body)), nil
+}
+
+// Close removes any temp file that ParseMIMEBodyWithOptions spilled mp's
+// body to, recursing into Children. It is a no-op for parts that never
+// spilled. Any body larger than ParseOptions.MaxInMemory (1 MiB by
+// default) is spilled, so callers should call Close once done with the
+// MultiPart tree.
+func (mp *MultiPart) Close() error {
+	var firstErr error
+	if f, ok := mp.source.(fileSource); ok {
+		if err := os.Remove(string(f)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for i := range mp.Children {
+		if err := mp.Children[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // IsMultiPart returns true if mp is a multipart message and may
@@ -109,9 +181,18 @@ func (mp *MultiPart) FindByFilenameRegex(re *regexp.Regexp) []*MultiPart {
 	return resultSet
 }
 
-// ParseMIMEBody parses the MIME payload from rawBody and partHeader. It supports
-// parsing nested multipart MIME payloads.
+// ParseMIMEBody parses the MIME payload from rawBody and partHeader
+// using the default ParseOptions. It supports parsing nested multipart
+// MIME payloads.
 func ParseMIMEBody(ctx context.Context, partHeader textproto.MIMEHeader, rawBody io.Reader) (*MultiPart, error) {
+	return ParseMIMEBodyWithOptions(ctx, partHeader, rawBody, ParseOptions{})
+}
+
+// ParseMIMEBodyWithOptions is like ParseMIMEBody but lets callers
+// bound how large a leaf part body may grow in memory before it is
+// spilled to a temp file; see ParseOptions.MaxInMemory. A spilled body
+// is only reachable through MultiPart.Open, not MultiPart.Body.
+func ParseMIMEBodyWithOptions(ctx context.Context, partHeader textproto.MIMEHeader, rawBody io.Reader, opts ParseOptions) (*MultiPart, error) {
 	var result = new(MultiPart)
 
 	// Parse Content-Type header.
@@ -153,7 +234,7 @@ func ParseMIMEBody(ctx context.Context, partHeader textproto.MIMEHeader, rawBody
 				return result, err
 			}
 
-			child, err := ParseMIMEBody(ctx, p.Header, p)
+			child, err := ParseMIMEBodyWithOptions(ctx, p.Header, p, opts)
 			if err != nil {
 				logger.Errorf(ctx, "failed to parse part: %s", err)
 				continue
@@ -161,40 +242,191 @@ func ParseMIMEBody(ctx context.Context, partHeader textproto.MIMEHeader, rawBody
 			result.Children = append(result.Children, *child)
 		}
 	} else {
-		body, err := ioutil.ReadAll(bodyReader)
-		if err != nil {
+		if err := result.readBody(bodyReader, opts.maxInMemory()); err != nil {
 			return result, err
 		}
-		result.Body = body
 	}
 
 	return result, nil
 }
 
-func decodeBody(charsetStr, encoding string, body io.Reader) (io.Reader, error) {
-	var reader io.Reader = body
-	if strings.ToLower(charsetStr) == "iso-8859-1" {
-		var err error
-		reader, err = charset.NewReader("latin1", reader)
+// readBody reads bodyReader into mp.Body if it is no larger than
+// maxInMemory bytes, otherwise spills it to a temp file and records
+// that file as mp's lazy body source instead.
+func (mp *MultiPart) readBody(bodyReader io.Reader, maxInMemory int64) error {
+	buf, err := ioutil.ReadAll(io.LimitReader(bodyReader, maxInMemory+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(buf)) <= maxInMemory {
+		mp.Body = buf
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile("", "mailbox-part-*")
+	if err != nil {
+		return fmt.Errorf("spilling body to disk: %w", err)
+	}
+	defer tmp.Close()
+
+	if _, err := tmp.Write(buf); err != nil {
+		return fmt.Errorf("spilling body to disk: %w", err)
+	}
+	if _, err := io.Copy(tmp, bodyReader); err != nil {
+		return fmt.Errorf("spilling body to disk: %w", err)
+	}
+
+	mp.source = fileSource(tmp.Name())
+	return nil
+}
+
+// WriteMIME serializes mp as a single MIME entity onto w, including its
+// own Content-Type and Content-Disposition headers. Leaf bodies are
+// base64 encoded; multipart bodies recurse into their children under a
+// freshly generated boundary. It is the inverse of ParseMIMEBody.
+func (mp *MultiPart) WriteMIME(w io.Writer) error {
+	header, boundary := mimeHeader(mp)
+
+	if err := writeMIMEHeader(w, header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	return writeMIMEBody(w, mp, boundary)
+}
+
+// mimeHeader builds the Content-Type and Content-Disposition header
+// for mp. For multipart entities it also generates the boundary that
+// writeMIMEBody must be called with.
+func mimeHeader(mp *MultiPart) (textproto.MIMEHeader, string) {
+	header := make(textproto.MIMEHeader)
+
+	var boundary string
+	if mp.IsMultiPart() {
+		boundary = newBoundary()
+		header.Set("Content-Type", fmt.Sprintf("%s; boundary=%q", mp.MimeType, boundary))
+	} else {
+		header.Set("Content-Type", mp.MimeType)
+		header.Set("Content-Transfer-Encoding", "base64")
+	}
+
+	if mp.FileName != "" {
+		disposition := "attachment"
+		if mp.Inline {
+			disposition = "inline"
+		}
+		header.Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, mp.FileName))
+	}
+
+	return header, boundary
+}
+
+// writeMIMEBody writes mp's body onto w, recursing into Children for
+// multipart entities using boundary, which must be the boundary
+// returned alongside mp's header from mimeHeader.
+func writeMIMEBody(w io.Writer, mp *MultiPart, boundary string) error {
+	if !mp.IsMultiPart() {
+		body, err := mp.Open()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		defer body.Close()
+
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := io.Copy(enc, body); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for i := range mp.Children {
+		child := &mp.Children[i]
+
+		childHeader, childBoundary := mimeHeader(child)
+		pw, err := mw.CreatePart(childHeader)
+		if err != nil {
+			return err
+		}
+
+		if err := writeMIMEBody(pw, child, childBoundary); err != nil {
+			return err
 		}
 	}
 
-	switch strings.ToLower(encoding) {
-	case "", "7bit":
+	return mw.Close()
+}
+
+// writeMIMEHeader writes header onto w in MIME header form, each
+// field terminated with a CRLF as required by RFC 5322.
+func writeMIMEHeader(w io.Writer, header textproto.MIMEHeader) error {
+	for key, values := range header {
+		for _, value := range values {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newBoundary generates a random MIME multipart boundary.
+func newBoundary() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", buf)
+}
+
+// decodeBody wraps body in a Content-Transfer-Encoding decoder and
+// then a charset decoder, in that order: quoted-printable and base64
+// payloads are pure ASCII on the wire, and it's only the bytes that
+// come out the other side of the CTE decoder that are actually in
+// charsetStr. Decoding charset first would transcode the ASCII
+// envelope (a no-op) and leave the real 8-bit payload untouched.
+func decodeBody(charsetStr, cte string, body io.Reader) (io.Reader, error) {
+	var reader io.Reader = body
+
+	switch strings.ToLower(cte) {
+	case "", "7bit", "8bit", "binary":
+		// Already octet-for-octet transfer safe; nothing to decode.
 	case "quoted-printable":
-		// TODO(ppacher): multipart.Reader.NextPart() transparently converts
-		// a quoted-printable already so we might get rid of this one
-		reader = qprintable.NewDecoder(
-			qprintable.WindowsTextEncoding,
-			reader,
-		)
+		reader = quotedprintable.NewReader(reader)
 	case "base64":
 		reader = base64.NewDecoder(base64.StdEncoding, reader)
 	default:
-		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+		return nil, fmt.Errorf("unsupported encoding %q", cte)
+	}
+
+	return decodeCharset(charsetStr, reader)
+}
+
+// decodeCharset wraps body in a decoder that transcodes charsetStr to
+// UTF-8 using the IANA character set registry, so any charset a
+// real-world mailer advertises (windows-1252, koi8-r, ...) is
+// recognized, not just iso-8859-1. An empty, "utf-8" or "us-ascii"
+// charset is already UTF-8 compatible and returned unchanged.
+func decodeCharset(charsetStr string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charsetStr) {
+	case "", "utf-8", "us-ascii":
+		return body, nil
+	}
+
+	enc, err := ianaindex.IANA.Encoding(charsetStr)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", charsetStr, err)
+	}
+	if enc == nil {
+		return body, nil
 	}
 
-	return reader, nil
+	return enc.NewDecoder().Reader(body), nil
 }
@@ -0,0 +1,104 @@
+package mailbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TestDecodeBody covers charset x Content-Transfer-Encoding
+// combinations, in particular charset=iso-8859-1 (or similar) with
+// Content-Transfer-Encoding: quoted-printable, the most common
+// real-world combination and the one that regresses if charset
+// decoding runs before CTE decoding instead of after.
+func TestDecodeBody(t *testing.T) {
+	cases := []struct {
+		name    string
+		want    string
+		charset string
+		cte     string
+		encode  func(raw []byte) []byte
+	}{
+		{
+			name:    "quoted-printable over windows-1252",
+			want:    "café – straße",
+			charset: "windows-1252",
+			cte:     "quoted-printable",
+			encode: func(raw []byte) []byte {
+				var buf bytes.Buffer
+				w := quotedprintable.NewWriter(&buf)
+				_, _ = w.Write(raw)
+				_ = w.Close()
+				return buf.Bytes()
+			},
+		},
+		{
+			name:    "base64 over iso-8859-1",
+			want:    "café straße",
+			charset: "iso-8859-1",
+			cte:     "base64",
+			encode: func(raw []byte) []byte {
+				return []byte(base64.StdEncoding.EncodeToString(raw))
+			},
+		},
+		{
+			name:    "7bit over us-ascii",
+			want:    "hello world",
+			charset: "us-ascii",
+			cte:     "7bit",
+			encode: func(raw []byte) []byte {
+				return raw
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, err := charsetEncoder(tc.charset)
+			if err != nil {
+				t.Fatalf("charsetEncoder(%q): %s", tc.charset, err)
+			}
+			raw, err := enc(tc.want)
+			if err != nil {
+				t.Fatalf("encoding want to %q: %s", tc.charset, err)
+			}
+
+			wire := tc.encode(raw)
+
+			reader, err := decodeBody(tc.charset, tc.cte, bytes.NewReader(wire))
+			if err != nil {
+				t.Fatalf("decodeBody: %s", err)
+			}
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("reading decoded body: %s", err)
+			}
+
+			if string(got) != tc.want {
+				t.Errorf("decodeBody(%q, %q, ...) = %q, want %q", tc.charset, tc.cte, got, tc.want)
+			}
+		})
+	}
+}
+
+// charsetEncoder returns a function that encodes a UTF-8 string into
+// the raw bytes of charset, the inverse of decodeCharset, for building
+// wire-format test fixtures.
+func charsetEncoder(charset string) (func(string) ([]byte, error), error) {
+	switch charset {
+	case "us-ascii":
+		return func(s string) ([]byte, error) { return []byte(s), nil }, nil
+	case "windows-1252":
+		return func(s string) ([]byte, error) { return charmap.Windows1252.NewEncoder().Bytes([]byte(s)) }, nil
+	case "iso-8859-1":
+		return func(s string) ([]byte, error) { return charmap.ISO8859_1.NewEncoder().Bytes([]byte(s)) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported test charset %q", charset)
+	}
+}
@@ -0,0 +1,39 @@
+package mailbox
+
+// MarkSeen adds the \Seen flag to uids.
+func (cli *Client) MarkSeen(uids []uint32) error {
+	return cli.SetFlags(uids, []string{`\Seen`}, StoreAdd)
+}
+
+// SetFlags applies flags to uids according to mode.
+func (cli *Client) SetFlags(uids []uint32, flags []string, mode StoreMode) error {
+	return cli.backend.Store(uids, flags, mode)
+}
+
+// Move relocates uids into folder. Once moved, a UID is no longer
+// valid in the currently selected mailbox.
+func (cli *Client) Move(uids []uint32, folder string) error {
+	return cli.backend.Move(uids, folder)
+}
+
+// Copy duplicates uids into folder, leaving the originals untouched.
+func (cli *Client) Copy(uids []uint32, folder string) error {
+	return cli.backend.Copy(uids, folder)
+}
+
+// Delete flags uids \Deleted. The messages are only actually removed
+// once Expunge is called.
+func (cli *Client) Delete(uids []uint32) error {
+	return cli.SetFlags(uids, []string{`\Deleted`}, StoreAdd)
+}
+
+// Expunge permanently removes all messages flagged \Deleted from the
+// currently selected mailbox.
+func (cli *Client) Expunge() error {
+	return cli.backend.Expunge()
+}
+
+// ListFolders lists the folders available on the server.
+func (cli *Client) ListFolders() ([]Folder, error) {
+	return cli.backend.ListFolders()
+}
@@ -0,0 +1,420 @@
+package mailbox
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// mxkBackend implements Backend on top of the legacy, unmaintained
+// github.com/mxk/go-imap client. It is kept for compatibility with
+// existing deployments; new code should prefer DriverEmersion.
+type mxkBackend struct {
+	imap *imap.Client
+	cfg  Config
+}
+
+func connectMXK(info Config) (Backend, error) {
+	var (
+		client *imap.Client
+		err    error
+	)
+	if info.TLS {
+		config := new(tls.Config)
+		config.InsecureSkipVerify = info.InsecureSkipVerify
+		client, err = imap.DialTLS(info.Host, config)
+	} else {
+		client, err = imap.Dial(info.Host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+
+	if info.User != "" {
+		if _, err := client.Login(info.User, info.Password); err != nil {
+			return nil, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if _, err := imap.Wait(client.Select(info.Folder, info.ReadOnly)); err != nil {
+		return nil, fmt.Errorf("selecting mailbox %q: %w", info.Folder, err)
+	}
+
+	return &mxkBackend{imap: client, cfg: info}, nil
+}
+
+func (b *mxkBackend) Search(criteria SearchCriteria) ([]uint32, error) {
+	tokens, err := criteria.Render()
+	if err != nil {
+		return nil, fmt.Errorf("rendering search criteria: %w", err)
+	}
+
+	specs := make([]imap.Field, 0, len(tokens)+2)
+	if len(tokens) == 0 {
+		// CHARSET must be followed by at least one search-key per the
+		// IMAP grammar, so it can't be emitted on its own; ALL matches
+		// an empty SearchCriteria (e.g. SearchUIDs("", time.Time{})).
+		specs = append(specs, "ALL")
+	} else {
+		specs = append(specs, "CHARSET", "UTF-8")
+		for _, tok := range tokens {
+			specs = append(specs, tok)
+		}
+	}
+
+	cmd, err := imap.Wait(b.imap.UIDSearch(specs...))
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []uint32
+	for _, data := range cmd.Data {
+		uids = append(uids, data.SearchResults()...)
+	}
+
+	return uids, nil
+}
+
+func (b *mxkBackend) Fetch(ctx context.Context, uids []uint32) (chan Response, error) {
+	ch := make(chan Response, 100)
+	if len(uids) == 0 {
+		close(ch)
+		return ch, nil
+	}
+
+	seq, _ := imap.NewSeqSet("")
+	seq.AddNum(uids...)
+
+	// We don't request ENVELOPE here: mxk's field map exposes it as an
+	// untyped nested list, and the RFC822 header parsing in
+	// MailFromFields already covers the fields we care about. The
+	// envelope fallback in mailFromRaw is exercised by emersionBackend
+	// instead.
+	cmd, err := b.imap.UIDFetch(
+		seq,
+		"INTERNALDATE",
+		"BODY[]",
+		"UID",
+		"RFC822.HEADER",
+		"FLAGS",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching mails: %w", err)
+	}
+
+	opts := ParseOptions{MaxInMemory: b.cfg.MaxInMemory}
+
+	go func() {
+		defer close(ch)
+
+		// Drain cmd.Data as responses arrive instead of waiting for the
+		// whole FETCH command to finish and processing cmd.Data in one
+		// go: mxk buffers each message's BODY[] literal fully in memory
+		// regardless, so this at least lets a 50MB attachment further
+		// down the UID set be parsed and handed off before the rest of
+		// the fetch has even completed.
+		for cmd.InProgress() {
+			if err := b.imap.Recv(time.Minute); err != nil {
+				ch <- Response{Err: fmt.Errorf("fetching mails: %w", err)}
+				return
+			}
+
+			for _, msgData := range cmd.Data {
+				msgFields := msgData.MessageInfo().Attrs
+
+				// make sure is a legit response before we attempt to parse it
+				// deal with unsolicited FETCH responses containing only flags
+				// I'm lookin' at YOU, Gmail!
+				// http://mailman13.u.washington.edu/pipermail/imap-protocol/2014-October/002355.html
+				// http://stackoverflow.com/questions/26262472/gmail-imap-is-sometimes-returning-bad-results-for-fetch
+				if _, ok := msgFields["RFC822.HEADER"]; !ok {
+					continue
+				}
+
+				mail, err := MailFromFieldsWithOptions(ctx, msgFields, opts)
+				ch <- Response{
+					EMail: mail,
+					Err:   err,
+				}
+			}
+			cmd.Data = nil
+		}
+	}()
+
+	return ch, nil
+}
+
+func (b *mxkBackend) Idle(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, 16)
+
+	if !b.imap.Caps["IDLE"] {
+		go b.pollIdle(ctx, ch)
+		return ch, nil
+	}
+
+	go b.idleLoop(ctx, ch)
+
+	return ch, nil
+}
+
+func (b *mxkBackend) idleLoop(ctx context.Context, ch chan<- Event) {
+	defer close(ch)
+
+	for ctx.Err() == nil {
+		if err := b.idleOnce(ctx, ch); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := b.reconnect(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// idleOnce issues a single IDLE command and blocks, translating
+// unsolicited server responses into events, until ctx is canceled,
+// idleRestartInterval elapses, or the connection breaks.
+func (b *mxkBackend) idleOnce(ctx context.Context, ch chan<- Event) error {
+	cmd, err := b.imap.Idle()
+	if err != nil {
+		return fmt.Errorf("starting idle: %w", err)
+	}
+
+	timer := time.NewTimer(idleRestartInterval)
+	defer timer.Stop()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		case <-stop:
+			return
+		}
+		b.imap.IdleTerm()
+	}()
+
+	for cmd.InProgress() {
+		if err := b.imap.Recv(time.Minute); err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+
+		for _, rsp := range b.imap.Data {
+			translateUnsolicited(rsp, ch)
+		}
+		b.imap.Data = nil
+	}
+
+	return nil
+}
+
+// translateUnsolicited converts an unsolicited IMAP response observed
+// while idling into an Event, if it is one we care about. EventNewMail
+// only carries Seq here, not UID: resolving it would require issuing a
+// command while IDLE is in progress, so callers must follow up with
+// Search/SearchUIDs if they need it; see Event.UID. pollIdle, used
+// when the server doesn't support IDLE at all, resolves the UID
+// directly instead.
+func translateUnsolicited(rsp *imap.Response, ch chan<- Event) {
+	switch rsp.Label {
+	case "EXISTS":
+		ch <- Event{Type: EventNewMail, Seq: imap.AsNumber(rsp.Fields[0])}
+	case "EXPUNGE":
+		ch <- Event{Type: EventExpunge, Seq: imap.AsNumber(rsp.Fields[0])}
+	case "FETCH":
+		info := rsp.MessageInfo()
+		var flags []string
+		if raw, ok := info.Attrs["FLAGS"]; ok {
+			for _, f := range imap.AsList(raw) {
+				flags = append(flags, imap.AsAtom(f))
+			}
+		}
+		ch <- Event{Type: EventFlagChange, Seq: info.Seq, Flags: flags}
+	}
+}
+
+// reconnect redials the server using the configuration that was
+// passed to Connect and swaps in the freshly established session.
+func (b *mxkBackend) reconnect() error {
+	fresh, err := connectMXK(b.cfg)
+	if err != nil {
+		return err
+	}
+	b.imap = fresh.(*mxkBackend).imap
+	return nil
+}
+
+// pollIdle emulates Idle by polling Search on the same cadence IDLE
+// would re-issue on, for servers that don't advertise IDLE.
+func (b *mxkBackend) pollIdle(ctx context.Context, ch chan<- Event) {
+	defer close(ch)
+
+	ticker := time.NewTicker(idleRestartInterval)
+	defer ticker.Stop()
+
+	lastPoll := time.Now()
+
+	// SINCE only has day granularity, so each poll also matches mail
+	// seen earlier that day; lastUID filters those out.
+	var lastUID uint32
+	if uids, err := b.Search(SearchCriteria{Since: lastPoll}); err == nil {
+		for _, uid := range uids {
+			if uid > lastUID {
+				lastUID = uid
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		since := lastPoll
+		lastPoll = time.Now()
+
+		uids, err := b.Search(SearchCriteria{Since: since})
+		if err != nil {
+			continue
+		}
+
+		newest := lastUID
+		for _, uid := range uids {
+			if uid <= lastUID {
+				continue
+			}
+			if uid > newest {
+				newest = uid
+			}
+			ch <- Event{Type: EventNewMail, UID: uid}
+		}
+		lastUID = newest
+	}
+}
+
+// Move copies uids into folder, flags the originals \Deleted and
+// expunges just those UIDs. mxk/go-imap predates the IMAP MOVE
+// extension, so there is no atomic single-command alternative.
+func (b *mxkBackend) Move(uids []uint32, folder string) error {
+	if err := b.Copy(uids, folder); err != nil {
+		return err
+	}
+
+	if err := b.Store(uids, []string{`\Deleted`}, StoreAdd); err != nil {
+		return fmt.Errorf("flagging for deletion: %w", err)
+	}
+
+	return b.expungeUIDs(uids)
+}
+
+// expungeUIDs issues a UID EXPUNGE scoped to uids (RFC 4315 UIDPLUS),
+// used by Move's fallback instead of the mailbox-wide Expunge: that
+// would also permanently remove any other message a caller flagged
+// \Deleted earlier via Store but hasn't expunged yet, as a side effect
+// of moving an unrelated UID set.
+func (b *mxkBackend) expungeUIDs(uids []uint32) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seq, _ := imap.NewSeqSet("")
+	seq.AddNum(uids...)
+
+	if _, err := imap.Wait(b.imap.Expunge(seq)); err != nil {
+		return fmt.Errorf("expunging %v: %w", uids, err)
+	}
+
+	return nil
+}
+
+func (b *mxkBackend) Copy(uids []uint32, folder string) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seq, _ := imap.NewSeqSet("")
+	seq.AddNum(uids...)
+
+	if _, err := imap.Wait(b.imap.UIDCopy(seq, folder)); err != nil {
+		return fmt.Errorf("copying to %q: %w", folder, err)
+	}
+
+	return nil
+}
+
+func (b *mxkBackend) Store(uids []uint32, flags []string, mode StoreMode) error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	seq, _ := imap.NewSeqSet("")
+	seq.AddNum(uids...)
+
+	item := "FLAGS"
+	switch mode {
+	case StoreAdd:
+		item = "+FLAGS"
+	case StoreRemove:
+		item = "-FLAGS"
+	}
+
+	if _, err := imap.Wait(b.imap.UIDStore(seq, item, imap.NewFlagSet(strings.Join(flags, " ")))); err != nil {
+		return fmt.Errorf("storing flags: %w", err)
+	}
+
+	return nil
+}
+
+func (b *mxkBackend) Expunge() error {
+	if b.cfg.ReadOnly {
+		return fmt.Errorf("mailbox is opened read-only")
+	}
+
+	if _, err := imap.Wait(b.imap.Expunge(nil)); err != nil {
+		return fmt.Errorf("expunging: %w", err)
+	}
+
+	return nil
+}
+
+func (b *mxkBackend) ListFolders() ([]Folder, error) {
+	cmd, err := imap.Wait(b.imap.List("", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("listing folders: %w", err)
+	}
+
+	var folders []Folder
+	for _, data := range cmd.Data {
+		info := data.MailboxInfo()
+
+		var attrs []string
+		for name, set := range info.Attrs {
+			if set {
+				attrs = append(attrs, name)
+			}
+		}
+
+		folders = append(folders, Folder{
+			Name:       info.Name,
+			Delimiter:  info.Delim,
+			Attributes: attrs,
+		})
+	}
+
+	return folders, nil
+}
+
+func (b *mxkBackend) Logout() error {
+	_, err := imap.Wait(b.imap.Logout(5 * time.Second))
+	return err
+}
@@ -0,0 +1,169 @@
+package mailbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchCriteria builds an IMAP SEARCH program. Zero-valued fields are
+// omitted from the rendered program, so callers only need to set the
+// criteria they care about. Not and Or allow composing criteria
+// arbitrarily.
+type SearchCriteria struct {
+	// From, To and Subject match against the respective header using
+	// a case-insensitive substring search, per the IMAP SEARCH FROM/
+	// TO/SUBJECT keys.
+	From, To, Subject string
+	// Body matches against the decoded message body text.
+	Body string
+	// Header matches arbitrary header fields by name; each entry
+	// renders as a HEADER <name> <value> search key.
+	Header map[string]string
+	// Since and Before restrict results to messages whose internal
+	// date falls on or after/strictly before the given day. Either may
+	// be left at the zero value to leave that bound unset.
+	Since, Before time.Time
+	// Larger and Smaller restrict results by message size in bytes.
+	// Zero means unset.
+	Larger, Smaller uint32
+	// Seen, Answered and Flagged are tri-state: nil leaves the
+	// criterion unset, true requires the flag, false requires its
+	// absence.
+	Seen, Answered, Flagged *bool
+	// Not, if set, negates the contained criteria.
+	Not *SearchCriteria
+	// Or, if it has at least two elements, matches messages satisfying
+	// any one of them.
+	Or []SearchCriteria
+	// Raw, if set, is appended to the rendered program as an
+	// already-valid IMAP search key verbatim, letting callers fall
+	// back to a hand-built search program where the struct fields
+	// don't reach. Only the mxk driver can splice a literal search-key
+	// in like this; the emersion driver's client library only exposes
+	// a structured SearchCriteria with no equivalent, so Search/
+	// SearchUIDs reject Raw with an error on that driver. See
+	// toEmersionCriteria.
+	Raw string
+}
+
+// Render renders c into a sequence of IMAP SEARCH program tokens,
+// quoting string literals and escaping them as required by the IMAP
+// grammar.
+func (c SearchCriteria) Render() ([]string, error) {
+	var tokens []string
+
+	if c.From != "" {
+		tokens = append(tokens, "FROM", quoteIMAPLiteral(c.From))
+	}
+	if c.To != "" {
+		tokens = append(tokens, "TO", quoteIMAPLiteral(c.To))
+	}
+	if c.Subject != "" {
+		tokens = append(tokens, "SUBJECT", quoteIMAPLiteral(c.Subject))
+	}
+	if c.Body != "" {
+		tokens = append(tokens, "BODY", quoteIMAPLiteral(c.Body))
+	}
+	for name, value := range c.Header {
+		tokens = append(tokens, "HEADER", quoteIMAPLiteral(name), quoteIMAPLiteral(value))
+	}
+	if !c.Since.IsZero() {
+		tokens = append(tokens, "SINCE", c.Since.Format(IMAPDateFormat))
+	}
+	if !c.Before.IsZero() {
+		tokens = append(tokens, "BEFORE", c.Before.Format(IMAPDateFormat))
+	}
+	if c.Larger > 0 {
+		tokens = append(tokens, "LARGER", strconv.FormatUint(uint64(c.Larger), 10))
+	}
+	if c.Smaller > 0 {
+		tokens = append(tokens, "SMALLER", strconv.FormatUint(uint64(c.Smaller), 10))
+	}
+	if c.Seen != nil {
+		tokens = append(tokens, triStateToken(*c.Seen, "SEEN", "UNSEEN"))
+	}
+	if c.Answered != nil {
+		tokens = append(tokens, triStateToken(*c.Answered, "ANSWERED", "UNANSWERED"))
+	}
+	if c.Flagged != nil {
+		tokens = append(tokens, triStateToken(*c.Flagged, "FLAGGED", "UNFLAGGED"))
+	}
+
+	if c.Not != nil {
+		notTokens, err := c.Not.Render()
+		if err != nil {
+			return nil, fmt.Errorf("rendering Not: %w", err)
+		}
+		if len(notTokens) == 0 {
+			return nil, fmt.Errorf("Not criteria renders to nothing")
+		}
+		tokens = append(tokens, "NOT", parenthesize(notTokens))
+	}
+
+	if len(c.Or) > 0 {
+		orTokens, err := renderOr(c.Or)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, orTokens...)
+	}
+
+	if c.Raw != "" {
+		tokens = append(tokens, c.Raw)
+	}
+
+	return tokens, nil
+}
+
+// renderOr folds a list of criteria into nested two-armed IMAP OR
+// search keys, since the IMAP grammar only defines OR for exactly two
+// search keys.
+func renderOr(criteria []SearchCriteria) ([]string, error) {
+	if len(criteria) == 1 {
+		return criteria[0].Render()
+	}
+
+	first, err := criteria[0].Render()
+	if err != nil {
+		return nil, fmt.Errorf("rendering Or: %w", err)
+	}
+	if len(first) == 0 {
+		return nil, fmt.Errorf("Or criteria renders to nothing")
+	}
+
+	rest, err := renderOr(criteria[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("Or criteria renders to nothing")
+	}
+
+	return []string{"OR", parenthesize(first), parenthesize(rest)}, nil
+}
+
+func triStateToken(value bool, yes, no string) string {
+	if value {
+		return yes
+	}
+	return no
+}
+
+// parenthesize wraps tokens in a single IMAP search-key group if more
+// than one token was rendered, leaving a lone token untouched.
+func parenthesize(tokens []string) string {
+	if len(tokens) == 1 {
+		return tokens[0]
+	}
+	return "(" + strings.Join(tokens, " ") + ")"
+}
+
+// quoteIMAPLiteral quotes s as an IMAP quoted string, escaping
+// backslashes and double quotes as required by the grammar.
+func quoteIMAPLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,74 @@
+package mailbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchCriteriaRender(t *testing.T) {
+	t.Parallel()
+
+	trueVal := true
+
+	cases := []struct {
+		name     string
+		criteria SearchCriteria
+		want     []string
+	}{
+		{
+			name:     "from with quotes and backslashes is escaped",
+			criteria: SearchCriteria{From: `a"b\c`},
+			want:     []string{"FROM", `"a\"b\\c"`},
+		},
+		{
+			name:     "single-element Or is folded in directly",
+			criteria: SearchCriteria{Or: []SearchCriteria{{From: "a"}}},
+			want:     []string{"FROM", `"a"`},
+		},
+		{
+			name: "two-element Or renders a single OR pair",
+			criteria: SearchCriteria{Or: []SearchCriteria{
+				{From: "a"}, {From: "b"},
+			}},
+			want: []string{"OR", `(FROM "a")`, `(FROM "b")`},
+		},
+		{
+			name: "three-element Or folds pairwise",
+			criteria: SearchCriteria{Or: []SearchCriteria{
+				{From: "a"}, {From: "b"}, {From: "c"},
+			}},
+			want: []string{"OR", `(FROM "a")`, `(OR (FROM "b") (FROM "c"))`},
+		},
+		{
+			name:     "Not of a single key is not parenthesized",
+			criteria: SearchCriteria{Not: &SearchCriteria{Seen: &trueVal}},
+			want:     []string{"NOT", "SEEN"},
+		},
+		{
+			name:     "Raw is appended verbatim",
+			criteria: SearchCriteria{Raw: `HEADER X-Custom "v"`},
+			want:     []string{`HEADER X-Custom "v"`},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.criteria.Render()
+			if err != nil {
+				t.Fatalf("Render: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Render() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchCriteriaRenderEmptyOr(t *testing.T) {
+	t.Parallel()
+
+	_, err := SearchCriteria{Or: []SearchCriteria{}}.Render()
+	if err != nil {
+		t.Fatalf("Render on an empty Or should be a no-op, got: %s", err)
+	}
+}